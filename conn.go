@@ -3,14 +3,22 @@ package graphql
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/websocket"
 )
 
+//ErrConnectionLost is returned by Execute when the underlying connection is lost while a
+//call is in flight and either reconnection is disabled or all reconnect attempts failed
+var ErrConnectionLost = errors.New("graphql: connection lost")
+
 //GenerateSubscriptionID is a function that returns unique IDs used to track subscriptions.
 //By default UUIDv4's are used
 var GenerateSubscriptionID func() string = func() string {
@@ -19,70 +27,258 @@ var GenerateSubscriptionID func() string = func() string {
 
 //Conn is a connection to a GraphQL WebSocket endpoint
 type Conn struct {
-	conn  *websocket.Conn
-	debug bool
+	conn     *websocket.Conn
+	protocol protocol
+	debug    bool
+
+	//connectionInitTimeout is how long init waits for a connection_ack when the
+	//negotiated protocol requires one. See protocol.requiresConnectionAckTimeout
+	connectionInitTimeout time.Duration
+
+	//dialer, url, header and connectionParams are retained so a lost connection can be
+	//redialed. dialer is nil for a Conn not created through Dialer.Dial, which disables
+	//reconnection
+	dialer           *Dialer
+	url              string
+	header           http.Header
+	connectionParams *MessagePayloadConnectionInit
+
+	//closed is set once the Conn has given up reconnecting (or reconnection is disabled)
+	//and lost is closed at the same time, to unblock callers waiting on the connection
+	closed    bool
+	closeOnce sync.Once
+	lost      chan struct{}
+
+	//reconnecting single-flights reconnect attempts: disconnected is called by both reader
+	//and writer, and with a keepalive/ping loop enabled a dead connection can see several
+	//writes fail in quick succession, each of which would otherwise kick off its own
+	//redial. It is set by disconnected and cleared when reconnect returns
+	reconnecting atomic.Bool
+
+	//genStop and genDone delimit the current generation's reader/writer goroutines.
+	//reconnect closes genStop to ask them to retire and, since only writer's exit matters
+	//for write ordering (it's the sole writeCh consumer), waits on genDone - closed by
+	//writer when it returns - before starting a replacement writer on the new connection.
+	//Both are replaced with fresh channels for each new generation
+	genStop chan struct{}
+	genDone chan struct{}
+
+	//writeCh is the queue of outgoing Messages drained by writer. writeMu guards the
+	//fallback path: direct, synchronous writes made outside of writeCh, namely the
+	//connection_init handshake in init, which must complete before writer is started, and
+	//the termination Message written by Close
+	writeCh chan *Message
+	writeMu sync.Mutex
+
+	//lastKeepAlive and lastPong track the most recent keepalive/pong received, for the
+	//legacy and graphql-transport-ws keepalive watchdogs, respectively
+	lastKeepAlive time.Time
+	lastPong      time.Time
+
+	//errCh receives connection-scoped errors (malformed Messages, and in the future other
+	//conditions that don't fit Execute's or a subscription handler's error path) so callers
+	//can observe them even with debug disabled. It is never closed, to avoid a send racing
+	//a close
+	errCh chan error
 
-	subscriptions map[string]func(message *Message)
+	subscriptions map[string]*subscription
 	mu            *sync.RWMutex
 }
 
-func (c *Conn) reader() {
+//subscription tracks the payload and handler for a registered subscription so it can be
+//replayed against a redialed connection
+type subscription struct {
+	payload *MessagePayloadStart
+	handler func(message *Message)
+}
+
+//Subprotocol returns the name of the subprotocol negotiated for this Conn
+func (c *Conn) Subprotocol() string {
+	return c.protocol.subprotocol()
+}
+
+//Lost returns a channel that is closed once the Conn has permanently lost its connection,
+//either because reconnection is disabled (Dialer.ReconnectAttempts is zero), because
+//Dialer.ReconnectAttempts redial attempts have all failed, or because Close was called
+func (c *Conn) Lost() <-chan struct{} {
+	return c.lost
+}
+
+//Errors returns a channel of connection-scoped errors, such as malformed Messages received
+//from the server. These are never fatal on their own and are delivered in addition to, not
+//instead of, debug logging; the channel exists for callers who want to observe them (e.g.
+//for metrics) without enabling debug. The channel is never closed and sends are
+//non-blocking, so a slow or absent reader simply misses errors rather than blocking the Conn
+func (c *Conn) Errors() <-chan error {
+	return c.errCh
+}
+
+//emitError delivers err to Errors without blocking if nobody is receiving
+func (c *Conn) emitError(err error) {
+	select {
+	case c.errCh <- err:
+	default:
+	}
+}
+
+//enqueue queues msg to be sent by writer, or returns ErrConnectionLost if the Conn has
+//already given up
+func (c *Conn) enqueue(msg *Message) error {
+	select {
+	case c.writeCh <- msg:
+		return nil
+	case <-c.lost:
+		return ErrConnectionLost
+	}
+}
+
+//writer is the sole writer of the underlying websocket connection once init completes.
+//gorilla/websocket forbids concurrent writers, so every other method enqueues onto
+//writeCh instead of writing directly. conn and stop are fixed for the lifetime of one
+//generation: conn is the connection this writer, and only this writer, may write to, and
+//stop is closed by reconnect to retire this writer before a replacement is started, so at
+//most one writer is ever draining writeCh (and writing out messages in order). writer
+//closes done on exit so reconnect can wait for that to happen before publishing a new
+//writer; it also exits on the first write error instead of looping back onto the now-dead
+//conn, since every other buffered frame would otherwise also fail and call disconnected()
+//again
+func (c *Conn) writer(conn *websocket.Conn, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	for {
+		select {
+		case msg := <-c.writeCh:
+			c.writeMu.Lock()
+			err := conn.WriteJSON(msg)
+			c.writeMu.Unlock()
+
+			if err != nil {
+				if c.debug {
+					log.Println("DEBUG: Unable to write", msg.Type, "message:", err)
+				}
+				c.disconnected(err)
+				return
+			}
+		case <-stop:
+			return
+		case <-c.lost:
+			return
+		}
+	}
+}
+
+//reader is the sole reader of the underlying websocket connection for one generation.
+//conn and proto are fixed for its lifetime rather than read off c, both to avoid racing
+//reconnect's writes to c.conn/c.protocol and because a reader belongs to exactly one
+//generation: once reconnect retires it (stop closes), any Message still buffered on the
+//old conn is no longer this Conn's concern
+func (c *Conn) reader(conn *websocket.Conn, proto protocol, stop <-chan struct{}) {
 	for {
 		msg := new(Message)
-		if err := c.conn.ReadJSON(msg); err != nil && c.debug {
-			log.Println("DEBUG: Unable to parse Message:", err)
-			continue
+		if err := conn.ReadJSON(msg); err != nil {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if isDecodeError(err) {
+				if c.debug {
+					log.Println("DEBUG: Unable to parse Message:", err)
+				}
+				c.emitError(fmt.Errorf("graphql: unable to parse message: %v", err))
+				continue
+			}
+			c.disconnected(err)
+			return
 		}
 
-		if msg.Type == MessageTypeConnectionKeepAlive {
+		class := proto.classify(msg)
+
+		switch class {
+		case classKeepAlive:
+			c.mu.Lock()
+			c.lastKeepAlive = time.Now()
+			c.mu.Unlock()
+			continue
+		case classPong:
+			c.mu.Lock()
+			c.lastPong = time.Now()
+			c.mu.Unlock()
+			continue
+		case classPing:
+			if pong := proto.pong(msg); pong != nil {
+				c.enqueue(pong)
+			}
 			continue
 		}
 
 		c.mu.RLock()
-		if f, ok := c.subscriptions[msg.ID]; !ok {
+		sub, ok := c.subscriptions[msg.ID]
+		c.mu.RUnlock()
+		if !ok {
 			if c.debug {
 				fmt.Println("DEBUG: Message received for unknown subscription:", msg.ID)
 			}
 		} else {
-			go f(msg)
+			go sub.handler(msg)
 		}
-		c.mu.RUnlock()
 
-		if msg.Type == MessageTypeComplete && msg.ID != "" {
+		if class == classComplete {
 			c.mu.Lock()
 			delete(c.subscriptions, msg.ID)
 			c.mu.Unlock()
 		}
 
-		if msg.Type != MessageTypeComplete && msg.Type != MessageTypeData && c.debug {
+		if class == classUnknown && c.debug {
 			fmt.Println("DEBUG: Received unexpected Message with type:", msg.Type)
 		}
 	}
 }
 
-func (c *Conn) init(connectionParams *MessagePayloadConnectionInit) error {
-	msg := &Message{Type: MessageTypeConnectionInit}
-	if err := msg.SetPayload(connectionParams); err != nil {
+//isDecodeError reports whether err is a JSON decode error for a single malformed
+//Message, as opposed to a websocket read/connection error
+func isDecodeError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
+
+//init runs the connection_init handshake over conn/proto and blocks until a connection_ack
+//is received (or the handshake fails). It takes conn and proto explicitly, rather than
+//reading c.conn/c.protocol, so reconnect can complete the handshake on a freshly-dialed
+//connection before starting a writer bound to it - otherwise a queued frame could reach
+//the new socket before connection_init is sent
+func (c *Conn) init(conn *websocket.Conn, proto protocol, connectionParams *MessagePayloadConnectionInit) error {
+	msg, err := proto.connectionInit(connectionParams)
+	if err != nil {
 		return fmt.Errorf("Unable to marshal connectionParams: %v", err)
 	}
 
-	err := c.conn.WriteJSON(msg)
+	c.writeMu.Lock()
+	err = conn.WriteJSON(msg)
+	c.writeMu.Unlock()
 	if err != nil {
 		return fmt.Errorf("Unable to write %s message: %v", MessageTypeConnectionInit, err)
 	}
 
+	if proto.requiresConnectionAckTimeout() {
+		if err := conn.SetReadDeadline(time.Now().Add(c.connectionInitTimeout)); err != nil {
+			return fmt.Errorf("Unable to set read deadline: %v", err)
+		}
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
 	for {
 		msg := new(Message)
-		err = c.conn.ReadJSON(msg)
-		if err != nil {
+		if err := conn.ReadJSON(msg); err != nil {
 			return fmt.Errorf("Unable to parse message: %v", err)
 		}
-		switch msg.Type {
-		case MessageTypeConnectionAck:
+		switch proto.classify(msg) {
+		case classConnectionAck:
 			return nil
-		case MessageTypeConnectionKeepAlive:
+		case classKeepAlive:
 			continue
-		case MessageTypeConnectionError:
+		case classConnectionError:
 			return ParseError(msg.Payload)
 		default:
 			return fmt.Errorf("Unexpected message type: %s", msg.Type)
@@ -92,13 +288,20 @@ func (c *Conn) init(connectionParams *MessagePayloadConnectionInit) error {
 
 //Close closes the Conn or returns an error if one occurred
 func (c *Conn) Close() error {
-	err := c.conn.WriteJSON(&Message{Type: MessageTypeConnectionTerminate})
-	if err != nil {
-		return fmt.Errorf("Unable to write %s message: %v", MessageTypeConnectionTerminate, err)
+	//giveUp stops writer and the keepalive watchdogs and rejects further enqueues, so the
+	//termination Message (if any) is written directly rather than through writeCh
+	c.giveUp()
+
+	if msg := c.protocol.terminate(); msg != nil {
+		c.writeMu.Lock()
+		err := c.conn.WriteJSON(msg)
+		c.writeMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("Unable to write %s message: %v", msg.Type, err)
+		}
 	}
 
-	err = c.conn.Close()
-	if err != nil {
+	if err := c.conn.Close(); err != nil {
 		return fmt.Errorf("Unable to close websocket connection: %v", err)
 	}
 
@@ -108,22 +311,30 @@ func (c *Conn) Close() error {
 //Subscribe creates a GraphQL subscription with the given payload and returns its ID, or returns an error if one occurred.
 //Subscription Messages are passed to the given function handler as they are received
 func (c *Conn) Subscribe(payload *MessagePayloadStart, f func(message *Message)) (id string, err error) {
+	return c.subscribe(payload, func(id string) func(message *Message) { return f })
+}
+
+//subscribe is the shared implementation behind Subscribe and SubscribeTyped. makeHandler
+//is given the generated subscription ID so it can build a handler that closes over it,
+//e.g. to call Unsubscribe(id) on itself
+func (c *Conn) subscribe(payload *MessagePayloadStart, makeHandler func(id string) func(message *Message)) (id string, err error) {
 	id = GenerateSubscriptionID()
+	handler := makeHandler(id)
 
-	m := &Message{Type: MessageTypeStart, ID: id}
-	if err := m.SetPayload(payload); err != nil {
+	m, err := c.protocol.subscribe(id, payload)
+	if err != nil {
 		return "", fmt.Errorf("Unable to marshal payload: %v", err)
 	}
 
 	c.mu.Lock()
-	c.subscriptions[id] = f
+	c.subscriptions[id] = &subscription{payload: payload, handler: handler}
 	c.mu.Unlock()
 
-	if err := c.conn.WriteJSON(m); err != nil {
+	if err := c.enqueue(m); err != nil {
 		c.mu.Lock()
 		delete(c.subscriptions, id)
 		c.mu.Unlock()
-		return "", fmt.Errorf("Unable to write %s message: %v", MessageTypeStart, err)
+		return "", fmt.Errorf("Unable to write %s message: %v", m.Type, err)
 	}
 
 	return id, nil
@@ -131,10 +342,10 @@ func (c *Conn) Subscribe(payload *MessagePayloadStart, f func(message *Message))
 
 //Unsubscribe stops the subscription with the given ID or returns an error if one occurred
 func (c *Conn) Unsubscribe(id string) error {
-	m := &Message{Type: MessageTypeStop, ID: id}
+	m := c.protocol.unsubscribe(id)
 
-	if err := c.conn.WriteJSON(m); err != nil {
-		return fmt.Errorf("Unable to write %s message: %v", MessageTypeStop, err)
+	if err := c.enqueue(m); err != nil {
+		return fmt.Errorf("Unable to write %s message: %v", m.Type, err)
 	}
 
 	c.mu.Lock()
@@ -165,21 +376,23 @@ func (c *Conn) Execute(ctx context.Context, payload *MessagePayloadStart) (data
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
+		case <-c.lost:
+			return nil, ErrConnectionLost
 		case msg := <-ch:
-			switch msg.Type {
-			case MessageTypeComplete:
+			switch c.protocol.classify(msg) {
+			case classComplete:
 				continue
-			case MessageTypeData:
+			case classData:
 				d := new(MessagePayloadData)
 				if err = json.Unmarshal(msg.Payload, d); err != nil {
-					return nil, fmt.Errorf("Unable to unmarshal %s message payload: %v", MessageTypeData, err)
+					return nil, fmt.Errorf("Unable to unmarshal %s message payload: %v", msg.Type, err)
 				}
 				return d, nil
-			case MessageTypeError:
+			case classError:
 				return nil, ParseError(msg.Payload)
 			default:
 				return nil, fmt.Errorf("Unexpected message type: %s", msg.Type)
 			}
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,157 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//DefaultConnectionInitTimeout is the default time Dialer.Dial waits for a connection_ack
+//after sending connection_init when the graphql-transport-ws protocol is negotiated
+const DefaultConnectionInitTimeout = 5 * time.Second
+
+//DefaultDialer is a Dialer with all default options
+var DefaultDialer = &Dialer{Dialer: websocket.DefaultDialer}
+
+//Dialer contains options for connecting to a GraphQL WebSocket endpoint
+type Dialer struct {
+	*websocket.Dialer
+
+	//Subprotocols is the list of subprotocols offered to the server, in order of
+	//preference. If empty, both SubprotocolTransportWS and SubprotocolLegacyWS are
+	//offered, preferring SubprotocolTransportWS
+	Subprotocols []string
+
+	//ConnectionInitTimeout is how long Dial waits for a connection_ack after sending
+	//connection_init when the graphql-transport-ws protocol is negotiated. A missing ack
+	//within this time is a fatal error. If zero, DefaultConnectionInitTimeout is used.
+	//The legacy protocol has no such handshake timeout
+	ConnectionInitTimeout time.Duration
+
+	//ReconnectAttempts is the number of times a Conn will try to redial and resume its
+	//subscriptions after the underlying websocket connection is lost. Zero (the default)
+	//disables reconnection entirely; a negative value retries forever
+	ReconnectAttempts int
+
+	//ReconnectBackoff returns how long to wait before the given reconnect attempt
+	//(1-indexed). If nil, DefaultReconnectBackoff is used
+	ReconnectBackoff func(attempt int) time.Duration
+
+	//OnReconnect, if non-nil, is called before each redial attempt: before the first with
+	//the error that triggered reconnection, and before each later one with the error that
+	//failed the previous attempt
+	OnReconnect func(err error)
+
+	//KeepaliveTimeout is how long a Conn using the legacy protocol will wait for a ka
+	//Message before treating the connection as dead and reconnecting (or giving up, if
+	//reconnection is disabled). Zero disables the watchdog. Unused by graphql-transport-ws
+	KeepaliveTimeout time.Duration
+
+	//PingInterval is how often a Conn using graphql-transport-ws sends a client Ping.
+	//Zero disables client-initiated pings. Unused by the legacy protocol, which has no
+	//client-initiated keepalive
+	PingInterval time.Duration
+
+	//PongTimeout is how long a Conn using graphql-transport-ws will wait for a Pong after
+	//a Ping before treating the connection as dead. If zero, PingInterval is used
+	PongTimeout time.Duration
+}
+
+//writeChanBufferSize is the capacity of Conn.writeCh
+const writeChanBufferSize = 64
+
+//errChanBufferSize is the capacity of Conn.errCh
+const errChanBufferSize = 16
+
+//Dial creates a new Conn with the given URL, HTTP headers, and connectionParams, or returns an error if one occurred
+func (d *Dialer) Dial(urlStr string, requestHeader http.Header, connectionParams *MessagePayloadConnectionInit) (*Conn, *http.Response, error) {
+	wsConn, proto, resp, err := d.dial(context.Background(), urlStr, requestHeader)
+	if err != nil {
+		return nil, resp, fmt.Errorf("Unable to dial websocket connection: %v", err)
+	}
+
+	connectionInitTimeout := d.ConnectionInitTimeout
+	if connectionInitTimeout <= 0 {
+		connectionInitTimeout = DefaultConnectionInitTimeout
+	}
+
+	genStop := make(chan struct{})
+	genDone := make(chan struct{})
+
+	conn := &Conn{
+		conn:                  wsConn,
+		protocol:              proto,
+		connectionInitTimeout: connectionInitTimeout,
+		dialer:                d,
+		url:                   urlStr,
+		header:                cloneHeader(requestHeader),
+		connectionParams:      connectionParams,
+		writeCh:               make(chan *Message, writeChanBufferSize),
+		errCh:                 make(chan error, errChanBufferSize),
+		subscriptions:         make(map[string]*subscription),
+		lost:                  make(chan struct{}),
+		genStop:               genStop,
+		genDone:               genDone,
+		mu:                    new(sync.RWMutex),
+	}
+
+	if err := conn.init(wsConn, proto, connectionParams); err != nil {
+		conn.conn.Close()
+		return nil, resp, fmt.Errorf("Unable to initialize connection: %v", err)
+	}
+
+	go conn.reader(wsConn, proto, genStop)
+	go conn.writer(wsConn, genStop, genDone)
+
+	switch proto.subprotocol() {
+	case SubprotocolLegacyWS:
+		if d.KeepaliveTimeout > 0 {
+			go conn.legacyKeepaliveLoop(d.KeepaliveTimeout)
+		}
+	case SubprotocolTransportWS:
+		if d.PingInterval > 0 {
+			pongTimeout := d.PongTimeout
+			if pongTimeout <= 0 {
+				pongTimeout = d.PingInterval
+			}
+			go conn.transportPingLoop(d.PingInterval, pongTimeout)
+		}
+	}
+
+	return conn, resp, nil
+}
+
+//dial negotiates the subprotocol and opens the underlying websocket connection. ctx bounds
+//only the dial itself (the TCP connect and WebSocket handshake), not the lifetime of the
+//resulting connection
+func (d *Dialer) dial(ctx context.Context, urlStr string, requestHeader http.Header) (*websocket.Conn, protocol, *http.Response, error) {
+	wsDialer := *d.Dialer
+
+	wsDialer.Subprotocols = d.Subprotocols
+	if len(wsDialer.Subprotocols) == 0 {
+		wsDialer.Subprotocols = []string{SubprotocolTransportWS, SubprotocolLegacyWS}
+	}
+
+	wsConn, resp, err := wsDialer.DialContext(ctx, urlStr, requestHeader)
+	if err != nil {
+		return nil, nil, resp, err
+	}
+
+	var proto protocol = legacyProtocol{}
+	if wsConn.Subprotocol() == SubprotocolTransportWS {
+		proto = transportProtocol{}
+	}
+
+	return wsConn, proto, resp, nil
+}
+
+func cloneHeader(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+	return header.Clone()
+}
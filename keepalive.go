@@ -0,0 +1,68 @@
+package graphql
+
+import "time"
+
+//legacyKeepaliveLoop closes the underlying connection (triggering reconnect, if enabled)
+//if no ka Message arrives within timeout. It runs for the lifetime of the Conn, surviving
+//reconnects, since reader records every received ka regardless of which dial it came from
+func (c *Conn) legacyKeepaliveLoop(timeout time.Duration) {
+	c.mu.Lock()
+	c.lastKeepAlive = time.Now()
+	c.mu.Unlock()
+
+	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.lost:
+			return
+		case <-ticker.C:
+			c.mu.RLock()
+			last := c.lastKeepAlive
+			conn := c.conn
+			c.mu.RUnlock()
+
+			if time.Since(last) > timeout {
+				conn.Close()
+				c.mu.Lock()
+				c.lastKeepAlive = time.Now()
+				c.mu.Unlock()
+			}
+		}
+	}
+}
+
+//transportPingLoop sends a client Ping every interval and closes the underlying
+//connection (triggering reconnect, if enabled) if no Pong arrives within pongTimeout of a
+//Ping being sent. It runs for the lifetime of the Conn, surviving reconnects
+func (c *Conn) transportPingLoop(interval, pongTimeout time.Duration) {
+	c.mu.Lock()
+	c.lastPong = time.Now()
+	c.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.lost:
+			return
+		case <-ticker.C:
+			c.mu.RLock()
+			last := c.lastPong
+			conn := c.conn
+			c.mu.RUnlock()
+
+			if time.Since(last) > interval+pongTimeout {
+				conn.Close()
+				c.mu.Lock()
+				c.lastPong = time.Now()
+				c.mu.Unlock()
+				continue
+			}
+
+			c.enqueue(c.protocol.ping())
+		}
+	}
+}
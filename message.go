@@ -0,0 +1,84 @@
+package graphql
+
+import (
+	"encoding/json"
+
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+//MessageType is the type of a Message exchanged over a GraphQL WebSocket connection.
+//The same string values are shared by both the legacy subscriptions-transport-ws
+//protocol and the newer graphql-transport-ws protocol where their semantics overlap;
+//see the protocol-specific constants below for the ones that are not shared.
+type MessageType string
+
+//Message types shared by both the legacy subscriptions-transport-ws protocol and the
+//graphql-transport-ws protocol
+const (
+	MessageTypeConnectionInit MessageType = "connection_init"
+	MessageTypeConnectionAck  MessageType = "connection_ack"
+	MessageTypeError          MessageType = "error"
+	MessageTypeComplete       MessageType = "complete"
+)
+
+//Message types used only by the legacy subscriptions-transport-ws protocol
+const (
+	MessageTypeConnectionError     MessageType = "connection_error"
+	MessageTypeConnectionKeepAlive MessageType = "ka"
+	MessageTypeConnectionTerminate MessageType = "connection_terminate"
+	MessageTypeStart               MessageType = "start"
+	MessageTypeData                MessageType = "data"
+	MessageTypeStop                MessageType = "stop"
+)
+
+//Message types used only by the graphql-transport-ws protocol
+const (
+	MessageTypeSubscribe MessageType = "subscribe"
+	MessageTypeNext      MessageType = "next"
+	MessageTypePing      MessageType = "ping"
+	MessageTypePong      MessageType = "pong"
+)
+
+//Message is a message exchanged over a GraphQL WebSocket connection
+type Message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    MessageType     `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+//SetPayload marshals the given value and sets it as the Message's Payload, or returns an error if one occurred
+func (m *Message) SetPayload(v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m.Payload = buf
+	return nil
+}
+
+//MessagePayloadConnectionInit is the payload sent with a connection_init Message
+type MessagePayloadConnectionInit map[string]interface{}
+
+//MessagePayloadStart is the payload sent with a start (subscriptions-transport-ws) or
+//subscribe (graphql-transport-ws) Message
+type MessagePayloadStart struct {
+	OperationName string                 `json:"operationName,omitempty"`
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+//MessagePayloadData is the payload sent with a data (subscriptions-transport-ws) or
+//next (graphql-transport-ws) Message
+type MessagePayloadData struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Errors gqlerror.List   `json:"errors,omitempty"`
+}
+
+//ParseError parses the payload of an error or connection_error Message into an error
+func ParseError(payload json.RawMessage) error {
+	errs := make(gqlerror.List, 0)
+	if err := json.Unmarshal(payload, &errs); err != nil {
+		return err
+	}
+	return errs
+}
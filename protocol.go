@@ -0,0 +1,59 @@
+package graphql
+
+//Subprotocol names recognized via the Sec-WebSocket-Protocol header. SubprotocolTransportWS
+//is preferred when a server advertises support for both
+const (
+	SubprotocolTransportWS = "graphql-transport-ws"
+	SubprotocolLegacyWS    = "graphql-ws"
+)
+
+//messageClass categorizes an incoming Message independent of which protocol produced it,
+//so Conn can dispatch on it without knowing the wire format
+type messageClass int
+
+//messageClass values returned by protocol.classify
+const (
+	classUnknown messageClass = iota
+	classConnectionAck
+	classConnectionError
+	classKeepAlive
+	classPing
+	classPong
+	classData
+	classError
+	classComplete
+)
+
+//protocol implements the wire format for a GraphQL WebSocket subprotocol. Conn uses it to
+//build outgoing Messages and classify incoming ones so Subscribe, Execute and Unsubscribe
+//behave identically regardless of which subprotocol was negotiated
+type protocol interface {
+	//subprotocol returns the Sec-WebSocket-Protocol name this protocol implements
+	subprotocol() string
+
+	//connectionInit returns the Message used to start the connection_init handshake
+	connectionInit(params *MessagePayloadConnectionInit) (*Message, error)
+
+	//requiresConnectionAckTimeout reports whether failing to receive a connection_ack
+	//within the Dialer's configured timeout should be treated as a fatal error
+	requiresConnectionAckTimeout() bool
+
+	//subscribe returns the Message used to start a subscription with the given id
+	subscribe(id string, payload *MessagePayloadStart) (*Message, error)
+
+	//unsubscribe returns the Message used to stop the subscription with the given id
+	unsubscribe(id string) *Message
+
+	//terminate returns the Message used to gracefully close the connection, or nil if
+	//the protocol closes the underlying websocket connection directly instead
+	terminate() *Message
+
+	//ping returns the Message used to probe that the peer is still responsive
+	ping() *Message
+
+	//pong returns the Message sent in response to the given ping Message
+	pong(ping *Message) *Message
+
+	//classify categorizes an incoming Message
+	classify(msg *Message) messageClass
+}
@@ -0,0 +1,65 @@
+package graphql
+
+//legacyProtocol implements the legacy subscriptions-transport-ws protocol
+type legacyProtocol struct{}
+
+func (legacyProtocol) subprotocol() string {
+	return SubprotocolLegacyWS
+}
+
+func (legacyProtocol) connectionInit(params *MessagePayloadConnectionInit) (*Message, error) {
+	msg := &Message{Type: MessageTypeConnectionInit}
+	if err := msg.SetPayload(params); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (legacyProtocol) requiresConnectionAckTimeout() bool {
+	return false
+}
+
+func (legacyProtocol) subscribe(id string, payload *MessagePayloadStart) (*Message, error) {
+	msg := &Message{Type: MessageTypeStart, ID: id}
+	if err := msg.SetPayload(payload); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (legacyProtocol) unsubscribe(id string) *Message {
+	return &Message{Type: MessageTypeStop, ID: id}
+}
+
+func (legacyProtocol) terminate() *Message {
+	return &Message{Type: MessageTypeConnectionTerminate}
+}
+
+//ping is unused by the legacy protocol; the server alone sends keepalives
+func (legacyProtocol) ping() *Message {
+	return nil
+}
+
+//pong is unused by the legacy protocol; it has no client-initiated keepalive to answer
+func (legacyProtocol) pong(ping *Message) *Message {
+	return nil
+}
+
+func (legacyProtocol) classify(msg *Message) messageClass {
+	switch msg.Type {
+	case MessageTypeConnectionAck:
+		return classConnectionAck
+	case MessageTypeConnectionError:
+		return classConnectionError
+	case MessageTypeConnectionKeepAlive:
+		return classKeepAlive
+	case MessageTypeData:
+		return classData
+	case MessageTypeError:
+		return classError
+	case MessageTypeComplete:
+		return classComplete
+	default:
+		return classUnknown
+	}
+}
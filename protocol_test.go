@@ -0,0 +1,149 @@
+package graphql
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProtocolConnectionInit(t *testing.T) {
+	params := &MessagePayloadConnectionInit{"token": "abc"}
+
+	for _, proto := range []protocol{legacyProtocol{}, transportProtocol{}} {
+		msg, err := proto.connectionInit(params)
+		if err != nil {
+			t.Fatalf("%T: unexpected error: %v", proto, err)
+		}
+		if msg.Type != MessageTypeConnectionInit {
+			t.Errorf("%T: got type %q, want %q", proto, msg.Type, MessageTypeConnectionInit)
+		}
+
+		payload := make(MessagePayloadConnectionInit)
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			t.Fatalf("%T: unable to unmarshal payload: %v", proto, err)
+		}
+		if payload["token"] != "abc" {
+			t.Errorf("%T: got payload %v, want token=abc", proto, payload)
+		}
+	}
+}
+
+func TestProtocolSubscribeUnsubscribe(t *testing.T) {
+	tests := []struct {
+		proto           protocol
+		wantSubscribe   MessageType
+		wantUnsubscribe MessageType
+	}{
+		{legacyProtocol{}, MessageTypeStart, MessageTypeStop},
+		{transportProtocol{}, MessageTypeSubscribe, MessageTypeComplete},
+	}
+
+	payload := &MessagePayloadStart{Query: "subscription { users { id } }"}
+
+	for _, tt := range tests {
+		msg, err := tt.proto.subscribe("1", payload)
+		if err != nil {
+			t.Fatalf("%T: unexpected error: %v", tt.proto, err)
+		}
+		if msg.Type != tt.wantSubscribe {
+			t.Errorf("%T: subscribe got type %q, want %q", tt.proto, msg.Type, tt.wantSubscribe)
+		}
+		if msg.ID != "1" {
+			t.Errorf("%T: subscribe got id %q, want \"1\"", tt.proto, msg.ID)
+		}
+
+		unsub := tt.proto.unsubscribe("1")
+		if unsub.Type != tt.wantUnsubscribe {
+			t.Errorf("%T: unsubscribe got type %q, want %q", tt.proto, unsub.Type, tt.wantUnsubscribe)
+		}
+		if unsub.ID != "1" {
+			t.Errorf("%T: unsubscribe got id %q, want \"1\"", tt.proto, unsub.ID)
+		}
+	}
+}
+
+func TestProtocolPingPong(t *testing.T) {
+	legacy := legacyProtocol{}
+	if ping := legacy.ping(); ping != nil {
+		t.Errorf("legacyProtocol: got ping %v, want nil", ping)
+	}
+	if pong := legacy.pong(&Message{Type: MessageTypePing}); pong != nil {
+		t.Errorf("legacyProtocol: got pong %v, want nil", pong)
+	}
+
+	transport := transportProtocol{}
+	ping := transport.ping()
+	if ping == nil || ping.Type != MessageTypePing {
+		t.Fatalf("transportProtocol: got ping %v, want a %q Message", ping, MessageTypePing)
+	}
+
+	payload := json.RawMessage(`{"a":1}`)
+	pong := transport.pong(&Message{Type: MessageTypePing, Payload: payload})
+	if pong == nil || pong.Type != MessageTypePong {
+		t.Fatalf("transportProtocol: got pong %v, want a %q Message", pong, MessageTypePong)
+	}
+	if string(pong.Payload) != string(payload) {
+		t.Errorf("transportProtocol: got pong payload %s, want %s", pong.Payload, payload)
+	}
+}
+
+func TestLegacyProtocolClassify(t *testing.T) {
+	tests := []struct {
+		msgType MessageType
+		want    messageClass
+	}{
+		{MessageTypeConnectionAck, classConnectionAck},
+		{MessageTypeConnectionError, classConnectionError},
+		{MessageTypeConnectionKeepAlive, classKeepAlive},
+		{MessageTypeData, classData},
+		{MessageTypeError, classError},
+		{MessageTypeComplete, classComplete},
+		{MessageType("unknown"), classUnknown},
+	}
+
+	proto := legacyProtocol{}
+	for _, tt := range tests {
+		if got := proto.classify(&Message{Type: tt.msgType}); got != tt.want {
+			t.Errorf("classify(%q) = %v, want %v", tt.msgType, got, tt.want)
+		}
+	}
+}
+
+func TestTransportProtocolClassify(t *testing.T) {
+	tests := []struct {
+		msgType MessageType
+		want    messageClass
+	}{
+		{MessageTypeConnectionAck, classConnectionAck},
+		{MessageTypePing, classPing},
+		{MessageTypePong, classPong},
+		{MessageTypeNext, classData},
+		{MessageTypeError, classError},
+		{MessageTypeComplete, classComplete},
+		{MessageType("unknown"), classUnknown},
+	}
+
+	proto := transportProtocol{}
+	for _, tt := range tests {
+		if got := proto.classify(&Message{Type: tt.msgType}); got != tt.want {
+			t.Errorf("classify(%q) = %v, want %v", tt.msgType, got, tt.want)
+		}
+	}
+}
+
+func TestProtocolRequiresConnectionAckTimeout(t *testing.T) {
+	if (legacyProtocol{}).requiresConnectionAckTimeout() {
+		t.Error("legacyProtocol: requiresConnectionAckTimeout() = true, want false")
+	}
+	if !(transportProtocol{}).requiresConnectionAckTimeout() {
+		t.Error("transportProtocol: requiresConnectionAckTimeout() = false, want true")
+	}
+}
+
+func TestProtocolTerminate(t *testing.T) {
+	if msg := (legacyProtocol{}).terminate(); msg == nil || msg.Type != MessageTypeConnectionTerminate {
+		t.Errorf("legacyProtocol: terminate() = %v, want a %q Message", msg, MessageTypeConnectionTerminate)
+	}
+	if msg := (transportProtocol{}).terminate(); msg != nil {
+		t.Errorf("transportProtocol: terminate() = %v, want nil", msg)
+	}
+}
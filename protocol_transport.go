@@ -0,0 +1,65 @@
+package graphql
+
+//transportProtocol implements the graphql-ws v5+ graphql-transport-ws protocol
+type transportProtocol struct{}
+
+func (transportProtocol) subprotocol() string {
+	return SubprotocolTransportWS
+}
+
+func (transportProtocol) connectionInit(params *MessagePayloadConnectionInit) (*Message, error) {
+	msg := &Message{Type: MessageTypeConnectionInit}
+	if err := msg.SetPayload(params); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (transportProtocol) requiresConnectionAckTimeout() bool {
+	return true
+}
+
+func (transportProtocol) subscribe(id string, payload *MessagePayloadStart) (*Message, error) {
+	msg := &Message{Type: MessageTypeSubscribe, ID: id}
+	if err := msg.SetPayload(payload); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (transportProtocol) unsubscribe(id string) *Message {
+	return &Message{Type: MessageTypeComplete, ID: id}
+}
+
+//terminate returns nil: graphql-transport-ws has no termination message, connections are
+//closed by simply closing the underlying websocket connection
+func (transportProtocol) terminate() *Message {
+	return nil
+}
+
+func (transportProtocol) ping() *Message {
+	return &Message{Type: MessageTypePing}
+}
+
+func (transportProtocol) pong(ping *Message) *Message {
+	return &Message{Type: MessageTypePong, Payload: ping.Payload}
+}
+
+func (transportProtocol) classify(msg *Message) messageClass {
+	switch msg.Type {
+	case MessageTypeConnectionAck:
+		return classConnectionAck
+	case MessageTypePing:
+		return classPing
+	case MessageTypePong:
+		return classPong
+	case MessageTypeNext:
+		return classData
+	case MessageTypeError:
+		return classError
+	case MessageTypeComplete:
+		return classComplete
+	default:
+		return classUnknown
+	}
+}
@@ -0,0 +1,148 @@
+package graphql
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+//DefaultReconnectBackoff is the default Dialer.ReconnectBackoff. It grows exponentially
+//from 1s, capping at 30s, with up to 50% jitter to avoid a thundering herd of reconnects
+func DefaultReconnectBackoff(attempt int) time.Duration {
+	maxBackoff := 30 * time.Second
+
+	base := time.Second
+	for i := 1; i < attempt && base < maxBackoff; i++ {
+		base *= 2
+	}
+	if base > maxBackoff {
+		base = maxBackoff
+	}
+
+	return base/2 + time.Duration(rand.Int63n(int64(base)/2+1))
+}
+
+//giveUp marks the Conn as permanently closed and unblocks anyone waiting on Lost. It is
+//safe to call more than once
+func (c *Conn) giveUp() {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.mu.Unlock()
+		close(c.lost)
+	})
+}
+
+//disconnected is called by reader and writer when the underlying websocket connection
+//fails. If reconnection is enabled via Dialer.ReconnectAttempts it kicks off a reconnect
+//attempt in the background, otherwise the Conn is given up for lost. reader and writer can
+//both observe the same failed connection (and a keepalive/ping loop can trigger additional
+//write failures while the first reconnect is still dialing), so reconnecting single-flights
+//this: only the caller that wins the compare-and-swap starts a reconnect
+func (c *Conn) disconnected(err error) {
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return
+	}
+
+	if c.dialer == nil || c.dialer.ReconnectAttempts == 0 {
+		c.giveUp()
+		return
+	}
+
+	if !c.reconnecting.CompareAndSwap(false, true) {
+		//a reconnect attempt is already in flight
+		return
+	}
+
+	go c.reconnect(err)
+}
+
+//reconnect redials, re-runs connection_init with the original connectionParams, and
+//re-issues every currently-registered subscription with its original payload and handler
+func (c *Conn) reconnect(err error) {
+	defer c.reconnecting.Store(false)
+
+	//retire the previous generation's reader/writer before starting a new one. Closing
+	//genStop asks both to return; waiting on genDone - closed by writer, the sole
+	//writeCh consumer, when it returns - guarantees the old writer has stopped draining
+	//writeCh before a replacement writer for the new connection is started, so frames are
+	//never split between two writers (and so never risk being written out of order, or to
+	//a conn that's already dead, because two writers raced for the same queued Message)
+	c.mu.RLock()
+	prevStop, prevDone := c.genStop, c.genDone
+	c.mu.RUnlock()
+
+	if prevStop != nil {
+		close(prevStop)
+	}
+	if prevDone != nil {
+		<-prevDone
+	}
+
+	backoff := c.dialer.ReconnectBackoff
+	if backoff == nil {
+		backoff = DefaultReconnectBackoff
+	}
+
+	for attempt := 1; c.dialer.ReconnectAttempts < 0 || attempt <= c.dialer.ReconnectAttempts; attempt++ {
+		if c.dialer.OnReconnect != nil {
+			c.dialer.OnReconnect(err)
+		}
+
+		time.Sleep(backoff(attempt))
+
+		wsConn, proto, _, dialErr := c.dialer.dial(context.Background(), c.url, c.header)
+		if dialErr != nil {
+			err = dialErr
+			continue
+		}
+
+		//complete the handshake on the new connection before starting a writer bound to it,
+		//so nothing queued on writeCh (a ping, a new Subscribe call) can reach the new
+		//socket before connection_init has been sent and acked on it
+		if initErr := c.init(wsConn, proto, c.connectionParams); initErr != nil {
+			err = initErr
+			wsConn.Close()
+			continue
+		}
+
+		genStop := make(chan struct{})
+		genDone := make(chan struct{})
+
+		c.mu.Lock()
+		c.conn = wsConn
+		c.protocol = proto
+		c.genStop = genStop
+		c.genDone = genDone
+		c.mu.Unlock()
+
+		go c.reader(wsConn, proto, genStop)
+		go c.writer(wsConn, genStop, genDone)
+		c.resubscribe()
+		return
+	}
+
+	c.giveUp()
+}
+
+//resubscribe re-issues every currently-registered subscription against the (already
+//reconnected) underlying connection
+func (c *Conn) resubscribe() {
+	c.mu.RLock()
+	subs := make(map[string]*subscription, len(c.subscriptions))
+	for id, sub := range c.subscriptions {
+		subs[id] = sub
+	}
+	c.mu.RUnlock()
+
+	for id, sub := range subs {
+		m, err := c.protocol.subscribe(id, sub.payload)
+		if err != nil {
+			continue
+		}
+		c.enqueue(m)
+	}
+}
@@ -0,0 +1,122 @@
+package graphql_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/korylprince/go-graphql-ws"
+)
+
+//newReconnectTestServer returns a test graphql-transport-ws server that, on its first
+//connection, acks connection_init and reads the subscribe Message but then closes the
+//socket without ever sending a result - simulating a dropped connection after a
+//subscription was already registered. Every later connection behaves normally: it acks
+//connection_init, reads subscribe, then sends a single next Message in reply
+func newReconnectTestServer(t *testing.T) (*httptest.Server, *int32) {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{Subprotocols: []string{graphql.SubprotocolTransportWS}}
+	var connCount int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("unable to upgrade connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		n := atomic.AddInt32(&connCount, 1)
+
+		init := new(graphql.Message)
+		if err := conn.ReadJSON(init); err != nil {
+			t.Errorf("server: unable to read connection_init: %v", err)
+			return
+		}
+		if init.Type != graphql.MessageTypeConnectionInit {
+			t.Errorf("server: got message type %q, want %q", init.Type, graphql.MessageTypeConnectionInit)
+			return
+		}
+		if err := conn.WriteJSON(&graphql.Message{Type: graphql.MessageTypeConnectionAck}); err != nil {
+			t.Errorf("server: unable to write connection_ack: %v", err)
+			return
+		}
+
+		sub := new(graphql.Message)
+		if err := conn.ReadJSON(sub); err != nil {
+			t.Errorf("server: unable to read subscribe: %v", err)
+			return
+		}
+		if sub.Type != graphql.MessageTypeSubscribe {
+			t.Errorf("server: got message type %q, want %q", sub.Type, graphql.MessageTypeSubscribe)
+			return
+		}
+
+		if n == 1 {
+			//drop the connection without responding, forcing the client to reconnect and
+			//resubscribe
+			return
+		}
+
+		data := &graphql.Message{Type: graphql.MessageTypeNext, ID: sub.ID}
+		if err := data.SetPayload(&graphql.MessagePayloadData{Data: []byte(`{"ok":true}`)}); err != nil {
+			t.Errorf("server: unable to set payload: %v", err)
+			return
+		}
+		if err := conn.WriteJSON(data); err != nil {
+			t.Errorf("server: unable to write next message: %v", err)
+			return
+		}
+
+		//keep the connection open so the client doesn't see a second, unexpected drop
+		<-r.Context().Done()
+	}))
+
+	return srv, &connCount
+}
+
+func TestConnReconnectResubscribes(t *testing.T) {
+	srv, connCount := newReconnectTestServer(t)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	dialer := &graphql.Dialer{
+		Dialer:            websocket.DefaultDialer,
+		ReconnectAttempts: 3,
+		ReconnectBackoff:  func(attempt int) time.Duration { return 10 * time.Millisecond },
+	}
+
+	conn, _, err := dialer.Dial(url, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	defer conn.Close()
+
+	received := make(chan *graphql.Message, 1)
+	if _, err := conn.Subscribe(&graphql.MessagePayloadStart{Query: "subscription { ping }"}, func(m *graphql.Message) {
+		received <- m
+	}); err != nil {
+		t.Fatalf("unable to subscribe: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.Type != graphql.MessageTypeNext {
+			t.Errorf("got message type %q, want %q", msg.Type, graphql.MessageTypeNext)
+		}
+	case <-conn.Lost():
+		t.Fatal("connection was given up on instead of reconnecting")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message after reconnect")
+	}
+
+	if got := atomic.LoadInt32(connCount); got < 2 {
+		t.Errorf("got %d server connections, want at least 2 (the dropped one and the reconnect)", got)
+	}
+}
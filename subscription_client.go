@@ -0,0 +1,366 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+//DefaultMaxSubscriptionsPerConn is the default SubscriptionClient.MaxSubscriptionsPerConn
+const DefaultMaxSubscriptionsPerConn = 1000
+
+//Updater receives Messages for an async subscription registered with SubscriptionClient.SubscribeAsync
+type Updater func(id uint64, msg *Message)
+
+//multiplexer reads from many upstream websocket connections at once without a
+//goroutine per connection. newMultiplexer is implemented per-platform: epoll on Linux,
+//a goroutine-per-connection fallback elsewhere
+type multiplexer interface {
+	//register begins multiplexed reading of conn, calling onMessage for every decoded
+	//Message until conn fails or unregister is called. If conn fails on its own (as
+	//opposed to being deregistered via unregister), onClose is called with the error
+	//instead of onMessage
+	register(conn *websocket.Conn, onMessage func(msg *Message), onClose func(err error)) error
+
+	//unregister stops reading the given conn
+	unregister(conn *websocket.Conn)
+
+	//close shuts down the multiplexer. It does not close the connections registered with it
+	close()
+}
+
+//upstreamConn is one of potentially many websocket connections a SubscriptionClient
+//dials to an upstream GraphQL WebSocket endpoint
+type upstreamConn struct {
+	conn     *websocket.Conn
+	protocol protocol
+	writeMu  sync.Mutex
+
+	//subCount is the number of subscriptions currently routed through this connection
+	//(including slots reserved for a subscription that's still being set up), guarded by
+	//SubscriptionClient.mu
+	subCount int
+
+	//pending is true from the moment a slot is reserved on a not-yet-dialed uc until its
+	//dial, connection_init handshake and multiplexer registration all succeed. It's
+	//guarded by SubscriptionClient.mu and keeps acquireConn from handing out additional
+	//slots on a connection that isn't usable yet
+	pending bool
+}
+
+func (uc *upstreamConn) writeJSON(v interface{}) error {
+	uc.writeMu.Lock()
+	defer uc.writeMu.Unlock()
+	return uc.conn.WriteJSON(v)
+}
+
+//asyncSubscription tracks which upstreamConn a SubscribeAsync subscription was issued on
+type asyncSubscription struct {
+	upstream *upstreamConn
+	updater  Updater
+}
+
+//SubscriptionClient manages many upstream GraphQL WebSocket connections and a large
+//number of subscriptions spread across them, multiplexed through a single read loop
+//instead of one goroutine per connection on Linux (elsewhere, each connection gets its own
+//reader goroutine). It is intended for routers and gateways that
+//need to run many downstream subscribers per upstream GraphQL server
+type SubscriptionClient struct {
+	dialer           *Dialer
+	url              string
+	header           http.Header
+	connectionParams *MessagePayloadConnectionInit
+
+	//MaxSubscriptionsPerConn bounds how many subscriptions are routed through a single
+	//upstream connection before SubscribeAsync dials another. If zero,
+	//DefaultMaxSubscriptionsPerConn is used
+	MaxSubscriptionsPerConn int
+
+	mux multiplexer
+
+	mu     sync.Mutex
+	conns  []*upstreamConn
+	subs   map[uint64]*asyncSubscription
+	nextID uint64
+}
+
+//NewSubscriptionClient creates a SubscriptionClient that will dial the given URL as
+//needed, or returns an error if one occurred
+func NewSubscriptionClient(dialer *Dialer, urlStr string, header http.Header, connectionParams *MessagePayloadConnectionInit) (*SubscriptionClient, error) {
+	mux, err := newMultiplexer()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create multiplexer: %v", err)
+	}
+
+	return &SubscriptionClient{
+		dialer:           dialer,
+		url:              urlStr,
+		header:           cloneHeader(header),
+		connectionParams: connectionParams,
+		mux:              mux,
+		subs:             make(map[uint64]*asyncSubscription),
+	}, nil
+}
+
+func (sc *SubscriptionClient) maxSubscriptionsPerConn() int {
+	if sc.MaxSubscriptionsPerConn > 0 {
+		return sc.MaxSubscriptionsPerConn
+	}
+	return DefaultMaxSubscriptionsPerConn
+}
+
+//acquireConn returns an upstream connection with spare capacity, dialing and registering
+//a new one with the multiplexer if every existing connection is at
+//MaxSubscriptionsPerConn. The dial and connection_init handshake - both blocking network
+//calls - run without holding sc.mu, so they never stall unrelated SubscribeAsync/
+//Unsubscribe calls; a reserved placeholder upstreamConn (pending, with its slot already
+//counted against MaxSubscriptionsPerConn) keeps a concurrent caller from handing out a slot
+//on a connection that isn't usable yet. A concurrent caller that finds only pending or full
+//connections dials a connection of its own rather than waiting for the pending one. ctx
+//bounds only the dial
+func (sc *SubscriptionClient) acquireConn(ctx context.Context) (*upstreamConn, error) {
+	sc.mu.Lock()
+	max := sc.maxSubscriptionsPerConn()
+	for _, uc := range sc.conns {
+		if !uc.pending && uc.subCount < max {
+			uc.subCount++
+			sc.mu.Unlock()
+			return uc, nil
+		}
+	}
+
+	uc := &upstreamConn{pending: true, subCount: 1}
+	sc.conns = append(sc.conns, uc)
+	sc.mu.Unlock()
+
+	wsConn, proto, _, err := sc.dialer.dial(ctx, sc.url, sc.header)
+	if err != nil {
+		sc.discardReserved(uc)
+		return nil, fmt.Errorf("Unable to dial websocket connection: %v", err)
+	}
+
+	uc.conn = wsConn
+	uc.protocol = proto
+
+	if err := sc.initUpstream(uc); err != nil {
+		wsConn.Close()
+		sc.discardReserved(uc)
+		return nil, fmt.Errorf("Unable to initialize connection: %v", err)
+	}
+
+	onMessage := func(msg *Message) { sc.dispatch(uc, msg) }
+	onClose := func(err error) { sc.connFailed(uc, err) }
+	if err := sc.mux.register(wsConn, onMessage, onClose); err != nil {
+		wsConn.Close()
+		sc.discardReserved(uc)
+		return nil, fmt.Errorf("Unable to register connection: %v", err)
+	}
+
+	sc.mu.Lock()
+	uc.pending = false
+	sc.mu.Unlock()
+
+	return uc, nil
+}
+
+//discardReserved removes a reserved upstreamConn that failed to dial, initialize, or
+//register from sc.conns, so later acquireConn calls don't keep seeing (and skipping) it
+func (sc *SubscriptionClient) discardReserved(uc *upstreamConn) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for i, c := range sc.conns {
+		if c == uc {
+			sc.conns = append(sc.conns[:i], sc.conns[i+1:]...)
+			break
+		}
+	}
+}
+
+//initUpstream runs the connection_init handshake on uc before it is registered with the
+//multiplexer
+func (sc *SubscriptionClient) initUpstream(uc *upstreamConn) error {
+	msg, err := uc.protocol.connectionInit(sc.connectionParams)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal connectionParams: %v", err)
+	}
+
+	if err := uc.conn.WriteJSON(msg); err != nil {
+		return fmt.Errorf("Unable to write %s message: %v", MessageTypeConnectionInit, err)
+	}
+
+	for {
+		ack := new(Message)
+		if err := uc.conn.ReadJSON(ack); err != nil {
+			return fmt.Errorf("Unable to parse message: %v", err)
+		}
+		switch uc.protocol.classify(ack) {
+		case classConnectionAck:
+			return nil
+		case classKeepAlive:
+			continue
+		case classConnectionError:
+			return ParseError(ack.Payload)
+		default:
+			return fmt.Errorf("Unexpected message type: %s", ack.Type)
+		}
+	}
+}
+
+//dispatch routes a Message read from uc to the subscription it belongs to, answering
+//Ping frames and ignoring keepalives along the way
+func (sc *SubscriptionClient) dispatch(uc *upstreamConn, msg *Message) {
+	switch uc.protocol.classify(msg) {
+	case classKeepAlive, classPong:
+		return
+	case classPing:
+		if pong := uc.protocol.pong(msg); pong != nil {
+			uc.writeJSON(pong)
+		}
+		return
+	}
+
+	id, err := strconv.ParseUint(msg.ID, 10, 64)
+	if err != nil {
+		return
+	}
+
+	sc.mu.Lock()
+	sub, ok := sc.subs[id]
+	sc.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	sub.updater(id, msg)
+
+	if uc.protocol.classify(msg) == classComplete {
+		sc.mu.Lock()
+		delete(sc.subs, id)
+		sub.upstream.subCount--
+		sc.mu.Unlock()
+	}
+}
+
+//connFailed is called by the multiplexer when uc's underlying connection fails on its own,
+//outside of an explicit Unsubscribe/Close. Without this, every subscription still routed
+//through uc would simply stop receiving updates with no indication why: subCount would
+//never be decremented and uc would linger in sc.conns. It removes uc and notifies every
+//subscription routed through it with a single synthesized error Message
+func (sc *SubscriptionClient) connFailed(uc *upstreamConn, err error) {
+	sc.mu.Lock()
+	var failedIDs []uint64
+	var failedSubs []*asyncSubscription
+	for id, sub := range sc.subs {
+		if sub.upstream == uc {
+			failedIDs = append(failedIDs, id)
+			failedSubs = append(failedSubs, sub)
+			delete(sc.subs, id)
+		}
+	}
+	for i, c := range sc.conns {
+		if c == uc {
+			sc.conns = append(sc.conns[:i], sc.conns[i+1:]...)
+			break
+		}
+	}
+	sc.mu.Unlock()
+
+	payload, marshalErr := json.Marshal(gqlerror.List{{Message: err.Error()}})
+	if marshalErr != nil {
+		return
+	}
+
+	for i, sub := range failedSubs {
+		sub.updater(failedIDs[i], &Message{Type: MessageTypeError, Payload: payload})
+	}
+}
+
+//SubscribeAsync creates a GraphQL subscription with the given payload and returns its
+//ID, or returns an error if one occurred. It returns as soon as the subscribe Message is
+//written, without waiting for any response; Messages are passed to updater as they
+//arrive until the subscription completes or Unsubscribe is called. The given context can
+//be used to cancel a pending dial when a new upstream connection is required
+func (sc *SubscriptionClient) SubscribeAsync(ctx context.Context, payload *MessagePayloadStart, updater Updater) (id uint64, err error) {
+	uc, err := sc.acquireConn(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	id = atomic.AddUint64(&sc.nextID, 1)
+
+	m, err := uc.protocol.subscribe(strconv.FormatUint(id, 10), payload)
+	if err != nil {
+		sc.mu.Lock()
+		uc.subCount--
+		sc.mu.Unlock()
+		return 0, fmt.Errorf("Unable to marshal payload: %v", err)
+	}
+
+	sc.mu.Lock()
+	sc.subs[id] = &asyncSubscription{upstream: uc, updater: updater}
+	sc.mu.Unlock()
+
+	if err := uc.writeJSON(m); err != nil {
+		sc.mu.Lock()
+		delete(sc.subs, id)
+		uc.subCount--
+		sc.mu.Unlock()
+		return 0, fmt.Errorf("Unable to write %s message: %v", m.Type, err)
+	}
+
+	return id, nil
+}
+
+//Unsubscribe stops the subscription with the given ID or returns an error if one occurred
+func (sc *SubscriptionClient) Unsubscribe(id uint64) error {
+	sc.mu.Lock()
+	sub, ok := sc.subs[id]
+	if ok {
+		delete(sc.subs, id)
+		sub.upstream.subCount--
+	}
+	sc.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Unknown subscription: %d", id)
+	}
+
+	m := sub.upstream.protocol.unsubscribe(strconv.FormatUint(id, 10))
+	if err := sub.upstream.writeJSON(m); err != nil {
+		return fmt.Errorf("Unable to write %s message: %v", m.Type, err)
+	}
+
+	return nil
+}
+
+//Close closes every upstream connection and shuts down the multiplexer, or returns the
+//first error that occurred
+func (sc *SubscriptionClient) Close() error {
+	sc.mu.Lock()
+	conns := sc.conns
+	sc.conns = nil
+	sc.mu.Unlock()
+
+	sc.mux.close()
+
+	var firstErr error
+	for _, uc := range conns {
+		//uc.conn is nil if Close raced with an acquireConn dial that reserved this slot but
+		//hasn't finished dialing yet
+		if uc.conn == nil {
+			continue
+		}
+		if err := uc.conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,194 @@
+//go:build linux
+
+package graphql
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/sys/unix"
+)
+
+//epollWaitTimeoutMillis bounds how long a single EpollWait call blocks, so epollMultiplexer.run
+//can periodically notice it has been asked to close
+const epollWaitTimeoutMillis = 1000
+
+//maxEpollEvents is the size of the EpollWait event buffer, i.e. the most ready
+//connections handled per iteration of the epoll loop
+const maxEpollEvents = 128
+
+//connHandler is the per-connection state looked up by fd when epoll reports readiness
+type connHandler struct {
+	conn      *websocket.Conn
+	onMessage func(msg *Message)
+	onClose   func(err error)
+
+	//draining is set while a drain goroutine is reading this connection's fd, so a
+	//repeat EPOLLIN wakeup for the same fd (level-triggered epoll fires again if bytes
+	//are still buffered once the in-flight drain's deadline read bails out) doesn't spawn
+	//a second goroutine racing it on the same *websocket.Conn
+	draining int32
+}
+
+//epollMultiplexer is the Linux implementation of multiplexer. A single runEpoll
+//goroutine services every registered connection via EpollWait instead of one goroutine
+//per connection
+type epollMultiplexer struct {
+	epfd     int
+	handlers sync.Map //map[int]*connHandler, keyed by fd
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+func newMultiplexer() (multiplexer, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create epoll instance: %v", err)
+	}
+
+	m := &epollMultiplexer{epfd: epfd, closeCh: make(chan struct{})}
+	go m.runEpoll()
+
+	return m, nil
+}
+
+//register adds conn to the epoll instance, level-triggered. The fd is left blocking: a
+//ReadJSON call that starts once epoll reports data waiting still reads a complete frame,
+//rather than racing a non-blocking fd into returning EAGAIN mid-frame, which gorilla's
+//reader has no way to resume from. Level-triggering means epoll simply reports the fd
+//ready again on the next wait if a frame was left only partially buffered
+func (m *epollMultiplexer) register(conn *websocket.Conn, onMessage func(msg *Message), onClose func(err error)) error {
+	fd, err := connFd(conn)
+	if err != nil {
+		return err
+	}
+
+	m.handlers.Store(fd, &connHandler{conn: conn, onMessage: onMessage, onClose: onClose})
+
+	event := unix.EpollEvent{Events: unix.EPOLLIN | unix.EPOLLRDHUP, Fd: int32(fd)}
+	if err := unix.EpollCtl(m.epfd, unix.EPOLL_CTL_ADD, fd, &event); err != nil {
+		m.handlers.Delete(fd)
+		return fmt.Errorf("Unable to add fd to epoll instance: %v", err)
+	}
+
+	return nil
+}
+
+func (m *epollMultiplexer) unregister(conn *websocket.Conn) {
+	fd, err := connFd(conn)
+	if err != nil {
+		return
+	}
+	m.deregister(fd)
+}
+
+//deregister removes fd from the epoll instance and drops its handler
+func (m *epollMultiplexer) deregister(fd int) {
+	unix.EpollCtl(m.epfd, unix.EPOLL_CTL_DEL, fd, nil)
+	m.handlers.Delete(fd)
+}
+
+func (m *epollMultiplexer) close() {
+	m.closeOnce.Do(func() {
+		close(m.closeCh)
+		unix.Close(m.epfd)
+	})
+}
+
+//runEpoll is the single goroutine that services every registered connection
+func (m *epollMultiplexer) runEpoll() {
+	events := make([]unix.EpollEvent, maxEpollEvents)
+
+	for {
+		select {
+		case <-m.closeCh:
+			return
+		default:
+		}
+
+		n, err := unix.EpollWait(m.epfd, events, epollWaitTimeoutMillis)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			v, ok := m.handlers.Load(fd)
+			if !ok {
+				continue
+			}
+			h := v.(*connHandler)
+			if !atomic.CompareAndSwapInt32(&h.draining, 0, 1) {
+				//a drain goroutine for this fd is already running
+				continue
+			}
+			//hand the ready fd off to its own goroutine rather than reading inline: ReadJSON
+			//blocks until a full frame arrives, and reading inline here would stall delivery
+			//to every other registered connection until it does
+			go m.drain(fd, h)
+		}
+	}
+}
+
+//drain reads every Message currently available on a ready fd and hands each to
+//h.onMessage. The fd is blocking, so the first ReadJSON call waits for a full frame; after
+//that, drain keeps reading with an immediate deadline to pick up any additional frames
+//gorilla's internal bufio.Reader already read ahead from a previous call - EpollWait is
+//keyed off the raw socket, so a frame sitting in that buffer is invisible to the next
+//EpollWait and would otherwise wait for more bytes to arrive on the wire before being
+//delivered
+func (m *epollMultiplexer) drain(fd int, h *connHandler) {
+	defer atomic.StoreInt32(&h.draining, 0)
+
+	msg := new(Message)
+	if err := h.conn.ReadJSON(msg); err != nil {
+		m.deregister(fd)
+		h.onClose(err)
+		return
+	}
+	h.onMessage(msg)
+
+	for {
+		h.conn.SetReadDeadline(time.Now())
+		msg := new(Message)
+		err := h.conn.ReadJSON(msg)
+		h.conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return
+			}
+			m.deregister(fd)
+			h.onClose(err)
+			return
+		}
+		h.onMessage(msg)
+	}
+}
+
+//connFd returns the raw file descriptor backing conn's underlying TCP connection
+func connFd(conn *websocket.Conn) (int, error) {
+	tcpConn, ok := conn.UnderlyingConn().(*net.TCPConn)
+	if !ok {
+		return 0, fmt.Errorf("Underlying connection is not a *net.TCPConn")
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to get raw connection: %v", err)
+	}
+
+	var fd int
+	if err := rawConn.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return 0, fmt.Errorf("Unable to get fd: %v", err)
+	}
+
+	return fd, nil
+}
@@ -0,0 +1,72 @@
+//go:build !linux
+
+package graphql
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+//goroutineMultiplexer is the fallback multiplexer for platforms without epoll/kqueue
+//support in this package: one reader goroutine per registered connection
+type goroutineMultiplexer struct {
+	mu    sync.Mutex
+	stops map[*websocket.Conn]chan struct{}
+}
+
+func newMultiplexer() (multiplexer, error) {
+	return &goroutineMultiplexer{stops: make(map[*websocket.Conn]chan struct{})}, nil
+}
+
+func (m *goroutineMultiplexer) register(conn *websocket.Conn, onMessage func(msg *Message), onClose func(err error)) error {
+	stop := make(chan struct{})
+
+	m.mu.Lock()
+	m.stops[conn] = stop
+	m.mu.Unlock()
+
+	go func() {
+		for {
+			msg := new(Message)
+			if err := conn.ReadJSON(msg); err != nil {
+				select {
+				case <-stop:
+				default:
+					onClose(err)
+				}
+				return
+			}
+
+			select {
+			case <-stop:
+				return
+			default:
+				onMessage(msg)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (m *goroutineMultiplexer) unregister(conn *websocket.Conn) {
+	m.mu.Lock()
+	stop, ok := m.stops[conn]
+	delete(m.stops, conn)
+	m.mu.Unlock()
+
+	if ok {
+		close(stop)
+	}
+}
+
+func (m *goroutineMultiplexer) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for conn, stop := range m.stops {
+		close(stop)
+		delete(m.stops, conn)
+	}
+}
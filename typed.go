@@ -0,0 +1,51 @@
+package graphql
+
+import (
+	"encoding/json"
+
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+//SubscribeTyped creates a GraphQL subscription with the given payload and returns its ID,
+//or returns an error if one occurred. Unlike Subscribe, incoming Messages are decoded for
+//the caller: onData is called with the subscription's data and/or errors as data (next) and
+//error Messages arrive. connection_error Messages carry no subscription ID and are not
+//passed to onData. Returning false from onData unsubscribes. onComplete is called when a
+//complete Message arrives for the subscription. Each Message is handled on its own
+//goroutine, so calls to onData and onComplete are not ordered with respect to each other
+func (c *Conn) SubscribeTyped(payload *MessagePayloadStart, onData func(data json.RawMessage, errs gqlerror.List) bool, onComplete func()) (id string, err error) {
+	return c.subscribe(payload, func(id string) func(message *Message) {
+		return func(message *Message) {
+			switch c.protocol.classify(message) {
+			case classData:
+				d := new(MessagePayloadData)
+				if err := json.Unmarshal(message.Payload, d); err != nil {
+					c.emitError(err)
+					return
+				}
+				if !onData(d.Data, d.Errors) {
+					c.Unsubscribe(id)
+				}
+			case classError:
+				if !onData(nil, errorsFromPayload(message.Payload)) {
+					c.Unsubscribe(id)
+				}
+			case classComplete:
+				if onComplete != nil {
+					onComplete()
+				}
+			}
+		}
+	})
+}
+
+//errorsFromPayload parses the payload of an error or connection_error Message into a
+//gqlerror.List, returning a single-element list describing the failure if payload itself
+//can't be parsed
+func errorsFromPayload(payload json.RawMessage) gqlerror.List {
+	errs := make(gqlerror.List, 0)
+	if err := json.Unmarshal(payload, &errs); err != nil {
+		return gqlerror.List{{Message: err.Error()}}
+	}
+	return errs
+}